@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Codec encodes and decodes the payloads Manager moves across the broker. ConnectBroker uses
+// whichever Codec is configured on ManagerOptions for the shard-routed SEND path, the republish
+// path, and the dispatch publish path, instead of hardcoding encoding/json.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+
+	// ContentType identifies the wire format, so it can be set as a broker header and let
+	// consumers on the other end pick the matching decoder.
+	ContentType() string
+}
+
+// RawJSONCodec is implemented by codecs that can transcode a raw JSON payload without going
+// through an intermediate interface{} (which silently lowers any integer beyond 2^53 to float64
+// and forces a full parse+re-serialize). Codecs that merely wrap JSON, like the zstd codec,
+// should implement this and transcode the bytes directly.
+type RawJSONCodec interface {
+	MarshalJSON(data []byte) ([]byte, error)
+}
+
+// codec returns the manager's configured Codec, defaulting to JSONCodec when none is set.
+func (m *Manager) codec() Codec {
+	if m.opts.Codec == nil {
+		return JSONCodec
+	}
+	return m.opts.Codec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+// JSONCodec is the default Codec, backed by encoding/json.
+var JSONCodec Codec = jsonCodec{}
+
+// transcodeDispatch re-encodes a JSON dispatch payload with codec. It takes the RawJSONCodec fast
+// path when available (e.g. zstd, which only needs to compress the existing bytes) and otherwise
+// decodes with json.Number so large integers (snowflake IDs beyond 2^53) survive the
+// interface{} hop intact instead of being rounded through float64.
+func transcodeDispatch(codec Codec, data []byte) ([]byte, error) {
+	if codec == JSONCodec {
+		return data, nil
+	}
+
+	if raw, ok := codec.(RawJSONCodec); ok {
+		return raw.MarshalJSON(data)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return codec.Marshal(v)
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// guildCreateFixture is a trimmed but representative GUILD_CREATE payload: enough members,
+// channels and roles to exercise codec throughput the way a busy guild would.
+var guildCreateFixture = buildGuildCreateFixture()
+
+func buildGuildCreateFixture() map[string]interface{} {
+	members := make([]interface{}, 0, 250)
+	for i := 0; i < 250; i++ {
+		members = append(members, map[string]interface{}{
+			"user": map[string]interface{}{
+				"id":            "123456789012345678",
+				"username":      "example_user",
+				"discriminator": "0001",
+				"avatar":        "a_1234567890abcdef1234567890abcdef",
+			},
+			"roles":     []string{"234567890123456789", "345678901234567890"},
+			"joined_at": "2020-01-01T00:00:00.000000+00:00",
+			"deaf":      false,
+			"mute":      false,
+		})
+	}
+
+	channels := make([]interface{}, 0, 25)
+	for i := 0; i < 25; i++ {
+		channels = append(channels, map[string]interface{}{
+			"id":   "456789012345678901",
+			"type": 0,
+			"name": "general",
+		})
+	}
+
+	return map[string]interface{}{
+		"id":           "111111111111111111",
+		"name":         "Example Guild",
+		"icon":         "abcdef0123456789abcdef0123456789",
+		"member_count": len(members),
+		"members":      members,
+		"channels":     channels,
+	}
+}
+
+func benchmarkCodec(b *testing.B, codec Codec) {
+	data, err := json.Marshal(guildCreateFixture)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var v map[string]interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		b.Fatal(err)
+	}
+
+	encoded, err := codec.Marshal(v)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		encoded, err = codec.Marshal(v)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		var decoded map[string]interface{}
+		if err := codec.Unmarshal(encoded, &decoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCodecJSON(b *testing.B) {
+	benchmarkCodec(b, JSONCodec)
+}
+
+func BenchmarkCodecETF(b *testing.B) {
+	benchmarkCodec(b, ETFCodec)
+}
+
+func BenchmarkCodecZstdJSON(b *testing.B) {
+	codec, err := NewZstdJSONCodec()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkCodec(b, codec)
+}
@@ -0,0 +1,15 @@
+package gateway
+
+import "github.com/spec-tacles/go/etf"
+
+type etfCodec struct{}
+
+func (etfCodec) Marshal(v interface{}) ([]byte, error) { return etf.Marshal(v) }
+
+func (etfCodec) Unmarshal(data []byte, v interface{}) error { return etf.Unmarshal(data, v) }
+
+func (etfCodec) ContentType() string { return "application/x-erlang-binary" }
+
+// ETFCodec encodes payloads using Discord's external term format, the same encoding its own
+// gateway and voice servers speak.
+var ETFCodec Codec = etfCodec{}
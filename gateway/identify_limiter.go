@@ -0,0 +1,213 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spec-tacles/go/broker"
+)
+
+// identifyInterval is the minimum spacing Discord enforces between two IDENTIFY payloads within
+// the same session-start-limit bucket.
+const identifyInterval = 5 * time.Second
+
+// ErrIdentifyBudgetExhausted is returned by Manager.Start when spawning the requested shards
+// would exceed the remaining daily identify budget reported by Discord's session start limit.
+type ErrIdentifyBudgetExhausted struct {
+	Remaining  int
+	ResetAfter time.Duration
+}
+
+func (e *ErrIdentifyBudgetExhausted) Error() string {
+	return fmt.Sprintf("identify budget exhausted: %d remaining, resets in %s", e.Remaining, e.ResetAfter)
+}
+
+// NewIdentifyLimiter returns an IdentifyLimiter that allows one identify per interval, matching
+// the pacing Discord requires within a single session-start-limit bucket. The first Lock fires
+// immediately; every subsequent one waits out the remainder of interval since the last.
+func NewIdentifyLimiter(interval time.Duration) IdentifyLimiter {
+	return &localIdentifyLimiter{interval: interval}
+}
+
+type localIdentifyLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func (l *localIdentifyLimiter) Lock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if wait := l.next.Sub(now); wait > 0 {
+		time.Sleep(wait)
+		now = time.Now()
+	}
+	l.next = now.Add(l.interval)
+}
+
+func (l *localIdentifyLimiter) Unlock() {}
+
+// nextAllowed reports when this limiter will next let a Lock through, so a caller coordinating a
+// wider guarantee than this one process — like releasing the distributed identify token to the
+// next waiter — can wait for it instead of enforcing its own separate, un-synchronized pacing.
+func (l *localIdentifyLimiter) nextAllowed() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.next
+}
+
+// identifyTokenPrefix is the broker event prefix used to coordinate a session-start-limit bucket
+// across every process in a ServerCount/ServerIndex deployment: whichever process holds the token
+// for a bucket is the only one allowed to identify shards in it.
+const identifyTokenPrefix = "identify_token."
+
+func identifyTokenEvent(bucket int) string {
+	return identifyTokenPrefix + strconv.Itoa(bucket)
+}
+
+// parseIdentifyTokenEvent reports whether event is an identify token message, and if so, which
+// bucket it belongs to, so ConnectBroker's callback can route it to identifyTokenChan instead of
+// treating it as a SEND/shard event.
+func parseIdentifyTokenEvent(event string) (bucket int, ok bool) {
+	if !strings.HasPrefix(event, identifyTokenPrefix) {
+		return 0, false
+	}
+
+	bucket, err := strconv.Atoi(event[len(identifyTokenPrefix):])
+	if err != nil {
+		return 0, false
+	}
+
+	return bucket, true
+}
+
+// brokerIdentifyLimiter wraps a local bucket limiter so that shards owned by other processes in
+// the same ServerCount/ServerIndex deployment serialize on the same bucket. The token for a
+// bucket is a single message on a broker queue that every process subscribes to: acquiring is
+// consuming that message, releasing is republishing it so the next waiter (on any process) picks
+// it up, no sooner than identifyInterval after it was acquired. See bucketSeeder for which process
+// mints each bucket's initial token.
+type brokerIdentifyLimiter struct {
+	local  IdentifyLimiter
+	m      *Manager
+	bucket int
+}
+
+func (l *brokerIdentifyLimiter) Lock() {
+	if err := l.m.acquireIdentifyToken(l.bucket); err != nil {
+		l.m.logger().Error("failed to acquire distributed identify token", "bucket", l.bucket, "error", err)
+	}
+	l.local.Lock()
+}
+
+func (l *brokerIdentifyLimiter) Unlock() {
+	// local.Lock already set the bucket's next-allowed time for this process, but republishing the
+	// token immediately would let the next waiter, on any process, acquire and identify before
+	// that interval elapses — local pacing alone doesn't bound the distributed handoff. Hold the
+	// release until then so the bucket's pacing is enforced across processes, not just within one.
+	if dl, ok := l.local.(*localIdentifyLimiter); ok {
+		if wait := time.Until(dl.nextAllowed()); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+
+	if err := l.m.releaseIdentifyToken(l.bucket); err != nil {
+		l.m.logger().Error("failed to release distributed identify token", "bucket", l.bucket, "error", err)
+	}
+	l.local.Unlock()
+}
+
+// identifyTokenChan returns the channel the ConnectBroker callback delivers bucket's token
+// message on, creating it on first use.
+func (m *Manager) identifyTokenChan(bucket int) chan []byte {
+	m.identifyTokensMu.Lock()
+	defer m.identifyTokensMu.Unlock()
+
+	if m.identifyTokens == nil {
+		m.identifyTokens = make(map[int]chan []byte)
+	}
+
+	ch, ok := m.identifyTokens[bucket]
+	if !ok {
+		ch = make(chan []byte, 1)
+		m.identifyTokens[bucket] = ch
+	}
+	return ch
+}
+
+// acquireIdentifyToken blocks until this process holds the identify token for bucket.
+func (m *Manager) acquireIdentifyToken(bucket int) error {
+	if m.broker == nil {
+		return nil
+	}
+
+	event := identifyTokenEvent(bucket)
+	if err := m.broker.Subscribe(event); err != nil {
+		return err
+	}
+
+	// Exactly one process is responsible for minting each bucket's initial token, since nothing
+	// else would ever publish the first one: the one that owns shard ID == bucket, the lowest
+	// shard number that bucket (shard_id % max_concurrency) ever contains. Without a Registry that
+	// ownership is deterministic from ServerIndex/ServerCount alone (see bucketSeeder); with one,
+	// the registry records it. Either way every bucket always has exactly one seeder regardless of
+	// ServerIndex — unlike always picking ServerIndex 0, which never seeds buckets owned solely by
+	// other indices and deadlocks them forever. Only do this once per bucket: every later acquire
+	// waits for the token Unlock republishes instead of minting a second, competing one.
+	seeds := m.bucketSeeder(bucket) == m.opts.ServerIndex
+	if m.opts.Registry != nil {
+		owner, ok, err := m.opts.Registry.Owner(context.Background(), bucket)
+		seeds = err == nil && ok && owner == m.opts.NodeID
+	}
+	if seeds && m.shouldSeedIdentifyToken(bucket) {
+		if err := m.broker.PublishOptions(broker.PublishOptions{Event: event, Data: []byte{1}}); err != nil {
+			return err
+		}
+	}
+
+	<-m.identifyTokenChan(bucket)
+	return nil
+}
+
+// bucketSeeder returns the ServerIndex responsible for minting bucket's initial token: whichever
+// process owns shard ID == bucket, the lowest shard number that bucket ever contains. Without a
+// Registry, shard IDs are handed out by Start as id, id+ServerCount, id+2*ServerCount, ...
+// starting from ServerIndex, so the owner of shard ID == bucket is bucket % ServerCount. With a
+// Registry, claimShards assigns IDs instead, and acquireIdentifyToken asks the registry for the
+// owner rather than using this.
+func (m *Manager) bucketSeeder(bucket int) int {
+	return bucket % m.opts.ServerCount
+}
+
+// shouldSeedIdentifyToken reports whether this is the first time bucket has been acquired on this
+// manager, so the caller mints its initial token exactly once.
+func (m *Manager) shouldSeedIdentifyToken(bucket int) bool {
+	m.identifyTokensMu.Lock()
+	defer m.identifyTokensMu.Unlock()
+
+	if m.identifyTokensSeeded == nil {
+		m.identifyTokensSeeded = make(map[int]struct{})
+	}
+	if _, ok := m.identifyTokensSeeded[bucket]; ok {
+		return false
+	}
+
+	m.identifyTokensSeeded[bucket] = struct{}{}
+	return true
+}
+
+// releaseIdentifyToken republishes bucket's token so the next waiter, on any process, can acquire
+// it.
+func (m *Manager) releaseIdentifyToken(bucket int) error {
+	if m.broker == nil {
+		return nil
+	}
+
+	return m.broker.PublishOptions(broker.PublishOptions{Event: identifyTokenEvent(bucket), Data: []byte{1}})
+}
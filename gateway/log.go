@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LogLevel is the verbosity threshold for gateway logging. It predates the move to log/slog and
+// is kept so existing ManagerOptions/ShardOptions.LogLevel values keep working; it maps directly
+// onto slog levels via Level.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+// Level returns the slog.Level equivalent of l.
+func (l LogLevel) Level() slog.Level {
+	switch l {
+	case LogLevelDebug:
+		return slog.LevelDebug
+	case LogLevelWarn:
+		return slog.LevelWarn
+	case LogLevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// levelHandler wraps a slog.Handler, enforcing a minimum level so ManagerOptions/ShardOptions'
+// LogLevel continues to gate verbosity no matter which handler the caller configured on Logger.
+type levelHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.Handler.Enabled(ctx, level)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// logger returns the manager's base structured logger, falling back to slog.Default() when no
+// *slog.Logger is configured, and leveled so LogLevel gates verbosity either way.
+func (m *Manager) logger() *slog.Logger {
+	base := m.opts.Logger
+	if base == nil {
+		base = slog.Default()
+	}
+	return slog.New(&levelHandler{Handler: base.Handler(), level: m.opts.LogLevel.Level()})
+}
@@ -1,9 +1,10 @@
 package gateway
 
 import (
-	"encoding/json"
+	"context"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/spec-tacles/gateway/stats"
@@ -23,8 +24,32 @@ type Manager struct {
 	Gateway     *types.GatewayBot
 	opts        *ManagerOptions
 	gatewayLock sync.Mutex
+	broker      *BrokerManager
+
+	// subscriptions holds the active *subscriptionMatcher, swapped atomically by
+	// UpdateSubscriptions so operators can change event forwarding without dropping packets.
+	subscriptions atomic.Value
+
+	// buckets holds one identify limiter per session-start-limit bucket (shard_id %
+	// max_concurrency), so shards in different buckets may identify concurrently while shards in
+	// the same bucket serialize.
+	buckets map[int]IdentifyLimiter
+
+	// identifyTokens delivers each bucket's distributed identify token, from the "identify_token."
+	// handler in ConnectBroker's callback to whichever local Lock call is waiting on it.
+	identifyTokensMu     sync.Mutex
+	identifyTokens       map[int]chan []byte
+	identifyTokensSeeded map[int]struct{}
+
+	// ordering publishes dispatch packets per-guild when ManagerOptions.OrderedPublish is set; nil
+	// otherwise, in which case the dispatch path publishes directly.
+	ordering *orderingPool
 }
 
+// defaultOrderingQueueSize bounds each per-guild ordering worker's channel when ManagerOptions
+// doesn't specify one.
+const defaultOrderingQueueSize = 256
+
 // NewManager creates a new Gateway manager
 func NewManager(opts *ManagerOptions) *Manager {
 	opts.init()
@@ -38,40 +63,71 @@ func NewManager(opts *ManagerOptions) *Manager {
 
 // Start starts all shards
 func (m *Manager) Start() (err error) {
+	log := m.logger()
+
+	g, err := m.FetchGateway()
+	if err != nil {
+		return
+	}
+
 	if m.opts.ShardCount == 0 {
-		var g *types.GatewayBot
-		g, err = m.FetchGateway()
+		log.Debug("shard count unspecified: using Discord recommended value")
+		m.opts.ShardCount = g.Shards
+	}
+
+	log = log.With("shard_count", m.opts.ShardCount)
+
+	var ids []int
+	if m.opts.Registry != nil && m.opts.ServerCount > 1 {
+		ids, err = m.claimShards()
 		if err != nil {
 			return
 		}
-
-		m.opts.ShardCount = g.Shards
 	} else {
-		m.log(LogLevelDebug, "Shard count unspecified: using Discord recommended value")
+		for i := m.opts.ServerIndex; i < m.opts.ShardCount; i += m.opts.ServerCount {
+			ids = append(ids, i)
+		}
+	}
+	expected := len(ids)
+
+	limit := g.SessionStartLimit
+	stats.SessionStartLimitRemaining.Set(float64(limit.Remaining))
+	if expected > limit.Remaining {
+		return &ErrIdentifyBudgetExhausted{
+			Remaining:  limit.Remaining,
+			ResetAfter: time.Duration(limit.ResetAfter) * time.Millisecond,
+		}
 	}
 
-	expected := m.opts.ShardCount / m.opts.ServerCount
-	if m.opts.ServerIndex < (m.opts.ShardCount % m.opts.ServerCount) {
-		expected++
+	maxConcurrency := limit.MaxConcurrency
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+
+	m.buckets = make(map[int]IdentifyLimiter, maxConcurrency)
+	for i := 0; i < maxConcurrency; i++ {
+		m.buckets[i] = NewIdentifyLimiter(identifyInterval)
 	}
 
-	m.log(LogLevelInfo, "Starting %d shard(s) out of %d total", expected, m.opts.ShardCount)
+	log.Info("starting shards", "expected", expected, "buckets", maxConcurrency)
 
 	wg := sync.WaitGroup{}
-	for i := m.opts.ServerIndex; i < m.opts.ShardCount; i += m.opts.ServerCount {
+	for _, i := range ids {
 		id := i
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 
+			shardLog := log.With("shard_id", id)
+
 			stats.TotalShards.Add(1)
 			defer stats.TotalShards.Sub(1)
 
 			err := m.Spawn(id)
 			if err != nil {
-				m.log(LogLevelError, "Fatal error in shard %d: %s", id, err)
+				shardLog.Error("fatal error in shard", "error", err)
 			} else {
-				m.log(LogLevelDebug, "Shard %d closing gracefully", id)
+				shardLog.Debug("shard closing gracefully")
 			}
 		}()
 	}
@@ -80,6 +136,38 @@ func (m *Manager) Start() (err error) {
 	return
 }
 
+// claimShards asks the registry for the authoritative shard count and claims this node's share of
+// shard IDs, one at a time, so scaling up new nodes doesn't require restarting existing ones.
+func (m *Manager) claimShards() ([]int, error) {
+	ctx := context.Background()
+
+	if err := m.opts.Registry.SetShardCount(ctx, m.opts.ShardCount); err != nil {
+		return nil, err
+	}
+
+	count, err := m.opts.Registry.ShardCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.opts.ShardCount = count
+
+	expected := count / m.opts.ServerCount
+	if m.opts.ServerIndex < (count % m.opts.ServerCount) {
+		expected++
+	}
+
+	ids := make([]int, 0, expected)
+	for i := 0; i < expected; i++ {
+		id, err := m.opts.Registry.ClaimNext(ctx, count, m.opts.NodeID)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
 // Spawn a new shard with the specified ID
 func (m *Manager) Spawn(id int) (err error) {
 	g, err := m.FetchGateway()
@@ -87,12 +175,20 @@ func (m *Manager) Spawn(id int) (err error) {
 		return
 	}
 
+	if m.opts.Registry != nil {
+		ctx := context.Background()
+		if err = m.opts.Registry.Register(ctx, id, m.opts.NodeID); err != nil {
+			return
+		}
+		defer m.opts.Registry.Deregister(ctx, id, m.opts.NodeID)
+	}
+
 	opts := m.opts.ShardOptions.clone()
 	opts.Identify.Shard = []int{id, m.opts.ShardCount}
 	opts.LogLevel = m.opts.LogLevel
-	opts.IdentifyLimiter = m.opts.ShardLimiter
+	opts.IdentifyLimiter = m.bucketLimiter(id)
 	if opts.Logger == nil {
-		opts.Logger = m.opts.Logger
+		opts.Logger = m.logger().With("shard_id", id, "shard_count", m.opts.ShardCount)
 	}
 
 	if m.opts.OnPacket != nil {
@@ -101,6 +197,23 @@ func (m *Manager) Spawn(id int) (err error) {
 		}
 	}
 
+	shardLabel := strconv.Itoa(id)
+	prevHeartbeatACK := opts.OnHeartbeatACK
+	opts.OnHeartbeatACK = func(rtt time.Duration) {
+		stats.HeartbeatRTT.WithLabelValues(shardLabel).Set(rtt.Seconds())
+		if prevHeartbeatACK != nil {
+			prevHeartbeatACK(rtt)
+		}
+	}
+
+	prevReconnect := opts.OnReconnect
+	opts.OnReconnect = func(reason string) {
+		stats.ReconnectReasons.WithLabelValues(shardLabel, reason).Inc()
+		if prevReconnect != nil {
+			prevReconnect(reason)
+		}
+	}
+
 	s := NewShard(opts)
 	s.Gateway = g
 	m.Shards[id] = s
@@ -113,6 +226,19 @@ func (m *Manager) Spawn(id int) (err error) {
 	return s.Close()
 }
 
+// bucketLimiter returns the identify limiter for the bucket shard id belongs to (bucket = id %
+// max_concurrency), wrapping it for cross-process cooperation when this deployment is split
+// across multiple servers via ServerCount/ServerIndex.
+func (m *Manager) bucketLimiter(id int) IdentifyLimiter {
+	local := m.buckets[id%len(m.buckets)]
+
+	if m.opts.ServerCount > 1 && m.broker != nil {
+		return &brokerIdentifyLimiter{local: local, m: m, bucket: id % len(m.buckets)}
+	}
+
+	return local
+}
+
 // FetchGateway fetches the gateway or from cache
 func (m *Manager) FetchGateway() (g *types.GatewayBot, err error) {
 	m.gatewayLock.Lock()
@@ -128,91 +254,163 @@ func (m *Manager) FetchGateway() (g *types.GatewayBot, err error) {
 }
 
 // ConnectBroker connects a broker to this manager. It forwards all packets from the gateway and
-// consumes packets from the broker for all shards it's responsible for.
-func (m *Manager) ConnectBroker(b *BrokerManager, events map[string]struct{}, timeout time.Duration) {
+// consumes packets from the broker for all shards it's responsible for. Only events matching spec
+// are forwarded; use UpdateSubscriptions to change spec afterwards without restarting shards.
+func (m *Manager) ConnectBroker(b *BrokerManager, spec SubscriptionSpec, timeout time.Duration) {
 	if b == nil {
 		return
 	}
 
+	m.broker = b
+	m.UpdateSubscriptions(spec)
+	log := m.logger()
+
+	if m.opts.OrderedPublish {
+		queueSize := m.opts.OrderingQueueSize
+		if queueSize == 0 {
+			queueSize = defaultOrderingQueueSize
+		}
+		m.ordering = newOrderingPool(b, log, m.opts.OrderingWorkers, queueSize, m.opts.BackpressureMode, m.opts.SpillDir)
+	}
+
 	m.opts.OnPacket = func(shard int, d *types.ReceivePacket) {
 		if d.Op != types.GatewayOpDispatch {
 			return
 		}
 
-		if _, ok := events[string(d.Event)]; !ok {
+		shardLabel := strconv.Itoa(shard)
+		switch string(d.Event) {
+		case "READY":
+			stats.Identifies.WithLabelValues(shardLabel).Inc()
+		case "RESUMED":
+			stats.Resumes.WithLabelValues(shardLabel).Inc()
+		}
+
+		if !m.matcher().Match(string(d.Event)) {
+			stats.EventsDropped.WithLabelValues(shardLabel, string(d.Event)).Inc()
+			return
+		}
+		stats.EventsReceived.WithLabelValues(shardLabel, string(d.Event)).Inc()
+
+		dispatchLog := log.With("shard_id", shard, "event", string(d.Event), "op", d.Op, "seq", d.Seq)
+
+		codec := m.codec()
+		data, err := transcodeDispatch(codec, d.Data)
+		if err != nil {
+			dispatchLog.Error("failed to encode dispatch payload", "error", err)
 			return
 		}
 
-		err := b.PublishOptions(broker.PublishOptions{
-			Event:   string(d.Event),
-			Data:    d.Data,
-			Timeout: timeout,
+		if m.ordering != nil {
+			// Scan the original JSON bytes for guild_id, not the transcoded data: non-JSON
+			// codecs (ETF, zstd) don't contain the literal "guild_id" key the scan looks for.
+			if guildID, ok := scanGuildID(d.Data); ok {
+				m.ordering.enqueue(guildID, orderingJob{
+					event:       string(d.Event),
+					data:        data,
+					contentType: codec.ContentType(),
+					timeout:     timeout,
+				})
+				return
+			}
+		}
+
+		start := time.Now()
+		err = b.PublishOptions(broker.PublishOptions{
+			Event:       string(d.Event),
+			Data:        data,
+			Timeout:     timeout,
+			ContentType: codec.ContentType(),
 		})
+		stats.PublishLatency.Observe(time.Since(start).Seconds())
 		if err != nil {
-			m.log(LogLevelError, "failed to publish packet to broker: %s", err)
+			dispatchLog.Error("failed to publish packet to broker", "error", err)
 		}
 	}
 
 	b.SetCallback(func(event string, d []byte) {
+		if bucket, ok := parseIdentifyTokenEvent(event); ok {
+			m.identifyTokenChan(bucket) <- d
+			return
+		}
+
 		var (
 			shard  *Shard
 			packet *types.SendPacket
 		)
+		sendLog := log.With("event", event)
+		codec := m.codec()
 		if event == "SEND" {
 			p := &UnknownSendPacket{}
-			err := json.Unmarshal(d, p)
+			err := codec.Unmarshal(d, p)
 			if err != nil {
-				m.log(LogLevelWarn, "unable to parse SEND packet: %s", err)
+				sendLog.Warn("unable to parse SEND packet", "error", err)
 				return
 			}
 
 			shardID := int(p.GuildID >> 22 % uint64(m.opts.ShardCount))
+			sendLog = sendLog.With("shard_id", shardID, "guild_id", p.GuildID, "op", p.Packet.Op)
 			shard = m.Shards[shardID]
 			if shard == nil {
-				data, err := json.Marshal(p.Packet)
+				stats.Republishes.WithLabelValues(strconv.Itoa(shardID)).Inc()
+
+				data, err := codec.Marshal(p.Packet)
 				if err != nil {
-					m.log(LogLevelError, "error serializing SEND packet data (%+v): %s", *p.Packet, err)
+					sendLog.Error("error serializing SEND packet data", "error", err)
 					return
 				}
 
+				target := strconv.Itoa(shardID)
+				if m.opts.Registry != nil {
+					if owner, ok, err := m.opts.Registry.Owner(context.Background(), shardID); err == nil && ok {
+						target = shardQueue(owner, shardID)
+					}
+				}
+
 				err = b.PublishOptions(broker.PublishOptions{
-					Event:   strconv.Itoa(shardID),
-					Data:    data,
-					Timeout: timeout,
+					Event:       target,
+					Data:        data,
+					Timeout:     timeout,
+					ContentType: codec.ContentType(),
 				})
 				if err != nil {
-					m.log(LogLevelError, "error re-publishing SEND packet data to shard %d: %s", shardID, err)
+					sendLog.Error("error re-publishing SEND packet data to shard", "error", err)
 				}
 				return
 			}
 			packet = p.Packet
 		} else {
-			shardID, err := strconv.Atoi(event)
+			shardID, err := parseShardEvent(event, m.opts.NodeID)
 			if err != nil {
-				m.log(LogLevelWarn, "received unexpected non-int event from AMQP: %s", err)
+				sendLog.Warn("received unexpected non-int event from AMQP", "error", err)
 			}
+			sendLog = sendLog.With("shard_id", shardID)
 			shard = m.Shards[shardID]
 			if shard == nil {
-				m.log(LogLevelWarn, "received event for shard %d which does not exist", shardID)
+				sendLog.Warn("received event for shard which does not exist")
 				return
 			}
 
-			err = json.Unmarshal(d, packet)
+			packet = &types.SendPacket{}
+			err = codec.Unmarshal(d, packet)
 			if err != nil {
-				m.log(LogLevelWarn, "unable to parse packet intended for shard %d: %s", shardID, err)
+				sendLog.Warn("unable to parse packet intended for shard", "error", err)
 				return
 			}
 		}
 
 		err := shard.Send(packet)
 		if err != nil {
-			m.log(LogLevelError, "error sending packet (%d): %s", packet.Op, err)
+			sendLog.Error("error sending packet", "op", packet.Op, "error", err)
 		}
 	})
 
 	go m.Subscribe(b, "SEND")
 	for id := range m.Shards {
 		go m.Subscribe(b, strconv.FormatInt(int64(id), 10))
+		if m.opts.Registry != nil {
+			go m.Subscribe(b, shardQueue(m.opts.NodeID, id))
+		}
 	}
 }
 
@@ -220,6 +418,6 @@ func (m *Manager) ConnectBroker(b *BrokerManager, events map[string]struct{}, ti
 func (m *Manager) Subscribe(b *BrokerManager, event string) {
 	err := b.Subscribe(event)
 	if err != nil {
-		m.log(LogLevelError, "failed to subscribe to event \"%s\": %s", event, err)
+		m.logger().With("event", event).Error("failed to subscribe to event", "error", err)
 	}
 }
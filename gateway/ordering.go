@@ -0,0 +1,252 @@
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spec-tacles/gateway/stats"
+	"github.com/spec-tacles/go/broker"
+)
+
+// BackpressureMode controls what an ordering worker does when its queue is full.
+type BackpressureMode int
+
+const (
+	// BackpressureBlock blocks the caller until the worker catches up.
+	BackpressureBlock BackpressureMode = iota
+	// BackpressureDropOldest discards the oldest queued packet to make room for the new one.
+	BackpressureDropOldest
+	// BackpressureSpillToDisk writes the packet to SpillDir instead of queueing it. Spilled
+	// packets are replayed from disk the next time a pool starts against the same SpillDir, and
+	// the directory is bounded to defaultMaxSpillFiles, evicting (and logging) the oldest spilled packet
+	// once full, so overflow is never silently unbounded or permanently lost.
+	BackpressureSpillToDisk
+)
+
+// defaultMaxSpillFiles bounds how many packets BackpressureSpillToDisk keeps on disk per pool
+// before it starts evicting the oldest one to make room.
+const defaultMaxSpillFiles = 10000
+
+// orderingJob is one packet queued for in-order publish by a single worker.
+type orderingJob struct {
+	event       string
+	data        []byte
+	contentType string
+	timeout     time.Duration
+}
+
+// orderingPool publishes dispatch packets through N worker goroutines, hashed by guild ID, so
+// that packets for the same guild are always published in the order the shard delivered them
+// while packets for different guilds publish concurrently.
+type orderingPool struct {
+	queues   []chan orderingJob
+	mode     BackpressureMode
+	spillDir string
+	b        *BrokerManager
+	log      *slog.Logger
+
+	spillMu    sync.Mutex
+	spillFiles []string // paths in creation order, oldest first
+	maxSpill   int
+}
+
+func newOrderingPool(b *BrokerManager, log *slog.Logger, workers, queueSize int, mode BackpressureMode, spillDir string) *orderingPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &orderingPool{
+		queues:   make([]chan orderingJob, workers),
+		mode:     mode,
+		spillDir: spillDir,
+		b:        b,
+		log:      log,
+		maxSpill: defaultMaxSpillFiles,
+	}
+
+	for i := range p.queues {
+		p.queues[i] = make(chan orderingJob, queueSize)
+		go p.run(p.queues[i])
+	}
+
+	if mode == BackpressureSpillToDisk && spillDir != "" {
+		p.drainSpillDir()
+	}
+
+	return p
+}
+
+func (p *orderingPool) run(q chan orderingJob) {
+	for job := range q {
+		start := time.Now()
+		err := p.b.PublishOptions(broker.PublishOptions{
+			Event:       job.event,
+			Data:        job.data,
+			Timeout:     job.timeout,
+			ContentType: job.contentType,
+		})
+		stats.PublishLatency.Observe(time.Since(start).Seconds())
+		if err != nil {
+			p.log.Error("failed to publish ordered packet", "event", job.event, "error", err)
+		}
+	}
+}
+
+// enqueue routes job to the worker owning guildID, applying the configured back-pressure mode if
+// that worker's queue is full.
+func (p *orderingPool) enqueue(guildID uint64, job orderingJob) {
+	q := p.queues[guildID%uint64(len(p.queues))]
+
+	select {
+	case q <- job:
+		return
+	default:
+	}
+
+	switch p.mode {
+	case BackpressureDropOldest:
+		select {
+		case <-q:
+		default:
+		}
+		select {
+		case q <- job:
+		default:
+		}
+	case BackpressureSpillToDisk:
+		if err := p.spill(guildID, job); err != nil {
+			p.log.Error("failed to spill packet to disk", "guild_id", guildID, "error", err)
+		}
+	default: // BackpressureBlock
+		q <- job
+	}
+}
+
+// spill persists job to SpillDir so it survives until a future pool drains it, instead of being
+// dropped. The directory is bounded to maxSpill packets; once full, the oldest spilled packet is
+// evicted (and the eviction logged) to make room.
+func (p *orderingPool) spill(guildID uint64, job orderingJob) error {
+	if err := os.MkdirAll(p.spillDir, 0o700); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%d-%s.%s", guildID, time.Now().UnixNano(), job.event, spillExtension(job.contentType))
+	path := filepath.Join(p.spillDir, name)
+
+	header := job.event + "\t" + job.contentType + "\n"
+	if err := os.WriteFile(path, append([]byte(header), job.data...), 0o600); err != nil {
+		return err
+	}
+
+	p.spillMu.Lock()
+	p.spillFiles = append(p.spillFiles, path)
+	if len(p.spillFiles) > p.maxSpill {
+		oldest := p.spillFiles[0]
+		p.spillFiles = p.spillFiles[1:]
+		p.log.Warn("spill directory full, evicting oldest packet", "path", oldest)
+		os.Remove(oldest)
+	}
+	p.spillMu.Unlock()
+
+	return nil
+}
+
+// drainSpillDir replays every packet left over in SpillDir from a previous run back through
+// enqueue, then removes it, so a spill is a delay rather than a permanent loss.
+func (p *orderingPool) drainSpillDir() {
+	entries, err := os.ReadDir(p.spillDir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(p.spillDir, entry.Name())
+
+		guildID, ok := guildIDFromSpillName(entry.Name())
+		if !ok {
+			continue
+		}
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			p.log.Error("failed to read spilled packet", "path", path, "error", err)
+			continue
+		}
+
+		idx := bytes.IndexByte(raw, '\n')
+		header := strings.SplitN(string(raw[:max(idx, 0)]), "\t", 2)
+		if idx < 0 || len(header) != 2 {
+			p.log.Warn("dropping malformed spilled packet", "path", path)
+			os.Remove(path)
+			continue
+		}
+
+		p.enqueue(guildID, orderingJob{event: header[0], contentType: header[1], data: raw[idx+1:]})
+		os.Remove(path)
+	}
+}
+
+func guildIDFromSpillName(name string) (uint64, bool) {
+	idx := strings.IndexByte(name, '-')
+	if idx < 0 {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(name[:idx], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// spillExtension turns a codec content-type into a filesystem-friendly extension, purely for
+// human inspection of the spill directory; it isn't used to decode the file.
+func spillExtension(contentType string) string {
+	ext := strings.TrimPrefix(contentType, "application/")
+	ext = strings.ReplaceAll(ext, "+", "-")
+	if ext == "" {
+		return "bin"
+	}
+	return ext
+}
+
+// scanGuildID extracts the value of the first "guild_id" key in a dispatch payload with a small
+// streaming byte scan rather than a full JSON unmarshal, since this runs on every packet. It does
+// not check nesting, so a "guild_id" inside a nested object that precedes the top-level one wins.
+func scanGuildID(data []byte) (uint64, bool) {
+	const key = `"guild_id"`
+
+	idx := bytes.Index(data, []byte(key))
+	if idx < 0 {
+		return 0, false
+	}
+
+	rest := data[idx+len(key):]
+	i := 0
+	for i < len(rest) && (rest[i] == ':' || rest[i] == ' ' || rest[i] == '"') {
+		i++
+	}
+
+	start := i
+	for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+		i++
+	}
+	if i == start {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(string(rest[start:i]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return id, true
+}
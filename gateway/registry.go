@@ -0,0 +1,48 @@
+package gateway
+
+import (
+	"context"
+	"strconv"
+)
+
+// ShardRegistry tracks which node owns each shard so publishers can route SEND packets directly
+// to that node's shard-specific queue instead of bouncing a misrouted packet back through the
+// broker. It also acts as the source of truth for the total shard count when ServerCount > 1, so
+// new nodes can claim unowned shard IDs without restarting existing ones.
+type ShardRegistry interface {
+	// Register claims shardID for nodeID. It returns an error if shardID is already claimed by a
+	// different node.
+	Register(ctx context.Context, shardID int, nodeID string) error
+
+	// Deregister releases shardID, e.g. on shutdown, so another node may claim it.
+	Deregister(ctx context.Context, shardID int, nodeID string) error
+
+	// Owner returns the node currently registered for shardID, or ok=false if unclaimed.
+	Owner(ctx context.Context, shardID int) (nodeID string, ok bool, err error)
+
+	// ClaimNext atomically claims the lowest-numbered unowned shard ID below shardCount for
+	// nodeID.
+	ClaimNext(ctx context.Context, shardCount int, nodeID string) (shardID int, err error)
+
+	// ShardCount returns the registry's source-of-truth total shard count, shared across nodes.
+	ShardCount(ctx context.Context) (int, error)
+
+	// SetShardCount publishes the authoritative shard count, if one isn't already set by another
+	// node.
+	SetShardCount(ctx context.Context, count int) error
+}
+
+// shardQueue returns the broker queue name on which nodeID receives SEND packets for a shard it
+// owns; other nodes publish to it after looking the owner up in the registry.
+func shardQueue(nodeID string, shardID int) string {
+	return "shard." + nodeID + "." + strconv.Itoa(shardID)
+}
+
+// parseShardEvent extracts a shard ID from either the legacy numeric event name or this node's
+// direct shard queue name ("shard.<nodeID>.<id>").
+func parseShardEvent(event, nodeID string) (int, error) {
+	if prefix := "shard." + nodeID + "."; len(event) > len(prefix) && event[:len(prefix)] == prefix {
+		return strconv.Atoi(event[len(prefix):])
+	}
+	return strconv.Atoi(event)
+}
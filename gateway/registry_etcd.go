@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EtcdRegistry is a ShardRegistry backed by an etcd key prefix, one key per shard, compare-and-
+// swapped via transactions so ClaimNext is atomic across nodes.
+type EtcdRegistry struct {
+	client *clientv3.Client
+	prefix string
+}
+
+// NewEtcdRegistry returns a ShardRegistry that stores shard ownership under keys
+// "<prefix>/shards/<id>" and the authoritative shard count under "<prefix>/count".
+func NewEtcdRegistry(client *clientv3.Client, prefix string) *EtcdRegistry {
+	return &EtcdRegistry{client: client, prefix: prefix}
+}
+
+func (r *EtcdRegistry) shardKey(shardID int) string {
+	return fmt.Sprintf("%s/shards/%d", r.prefix, shardID)
+}
+
+func (r *EtcdRegistry) Register(ctx context.Context, shardID int, nodeID string) error {
+	key := r.shardKey(shardID)
+
+	resp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, nodeID)).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if resp.Succeeded {
+		return nil
+	}
+
+	owner := string(resp.Responses[0].GetResponseRange().Kvs[0].Value)
+	if owner != nodeID {
+		return fmt.Errorf("shard %d already owned by %q", shardID, owner)
+	}
+	return nil
+}
+
+func (r *EtcdRegistry) Deregister(ctx context.Context, shardID int, nodeID string) error {
+	key := r.shardKey(shardID)
+
+	_, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", nodeID)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	return err
+}
+
+func (r *EtcdRegistry) Owner(ctx context.Context, shardID int) (string, bool, error) {
+	resp, err := r.client.Get(ctx, r.shardKey(shardID))
+	if err != nil {
+		return "", false, err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", false, nil
+	}
+	return string(resp.Kvs[0].Value), true, nil
+}
+
+func (r *EtcdRegistry) ClaimNext(ctx context.Context, shardCount int, nodeID string) (int, error) {
+	for id := 0; id < shardCount; id++ {
+		key := r.shardKey(id)
+
+		resp, err := r.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(clientv3.OpPut(key, nodeID)).
+			Commit()
+		if err != nil {
+			return 0, err
+		}
+		if resp.Succeeded {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no unowned shard below %d", shardCount)
+}
+
+func (r *EtcdRegistry) ShardCount(ctx context.Context) (int, error) {
+	resp, err := r.client.Get(ctx, r.prefix+"/count")
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, nil
+	}
+	return strconv.Atoi(string(resp.Kvs[0].Value))
+}
+
+// SetShardCount publishes the authoritative shard count, if one isn't already set by another
+// node.
+func (r *EtcdRegistry) SetShardCount(ctx context.Context, count int) error {
+	key := r.prefix + "/count"
+
+	_, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, strconv.Itoa(count))).
+		Commit()
+	return err
+}
@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// shardCountField stores the registry's authoritative total shard count alongside the per-shard
+// ownership fields in the same hash.
+const shardCountField = "_count"
+
+// RedisRegistry is a ShardRegistry backed by a Redis hash mapping shard ID to owning node ID.
+type RedisRegistry struct {
+	client *redis.Client
+	key    string
+}
+
+// NewRedisRegistry returns a ShardRegistry that stores shard ownership in the given Redis hash
+// key.
+func NewRedisRegistry(client *redis.Client, key string) *RedisRegistry {
+	return &RedisRegistry{client: client, key: key}
+}
+
+func (r *RedisRegistry) Register(ctx context.Context, shardID int, nodeID string) error {
+	field := strconv.Itoa(shardID)
+
+	ok, err := r.client.HSetNX(ctx, r.key, field, nodeID).Result()
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	owner, err := r.client.HGet(ctx, r.key, field).Result()
+	if err != nil {
+		return err
+	}
+	if owner != nodeID {
+		return fmt.Errorf("shard %d already owned by %q", shardID, owner)
+	}
+	return nil
+}
+
+func (r *RedisRegistry) Deregister(ctx context.Context, shardID int, nodeID string) error {
+	field := strconv.Itoa(shardID)
+
+	owner, err := r.client.HGet(ctx, r.key, field).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if owner != nodeID {
+		return nil
+	}
+
+	return r.client.HDel(ctx, r.key, field).Err()
+}
+
+func (r *RedisRegistry) Owner(ctx context.Context, shardID int) (string, bool, error) {
+	owner, err := r.client.HGet(ctx, r.key, strconv.Itoa(shardID)).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return owner, true, nil
+}
+
+func (r *RedisRegistry) ClaimNext(ctx context.Context, shardCount int, nodeID string) (int, error) {
+	for id := 0; id < shardCount; id++ {
+		ok, err := r.client.HSetNX(ctx, r.key, strconv.Itoa(id), nodeID).Result()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no unowned shard below %d", shardCount)
+}
+
+func (r *RedisRegistry) ShardCount(ctx context.Context) (int, error) {
+	v, err := r.client.HGet(ctx, r.key, shardCountField).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(v)
+}
+
+// SetShardCount publishes the authoritative shard count, if one isn't already set by another
+// node.
+func (r *RedisRegistry) SetShardCount(ctx context.Context, count int) error {
+	return r.client.HSetNX(ctx, r.key, shardCountField, count).Err()
+}
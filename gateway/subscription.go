@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/spec-tacles/go/types"
+)
+
+// SubscriptionSpec describes which dispatch events ConnectBroker forwards to the broker. It
+// accepts exact event names, glob patterns such as "GUILD_*" or "MESSAGE_REACTION_*", and gateway
+// intent groups that expand to their underlying event set.
+type SubscriptionSpec struct {
+	Events   []string
+	Patterns []string
+	Intents  []types.Intent
+}
+
+// intentEvents maps a gateway intent to the dispatch events it delivers.
+var intentEvents = map[types.Intent][]string{
+	types.IntentGuilds: {
+		"GUILD_CREATE", "GUILD_UPDATE", "GUILD_DELETE",
+		"GUILD_ROLE_CREATE", "GUILD_ROLE_UPDATE", "GUILD_ROLE_DELETE",
+		"CHANNEL_CREATE", "CHANNEL_UPDATE", "CHANNEL_DELETE",
+	},
+	types.IntentGuildMembers: {
+		"GUILD_MEMBER_ADD", "GUILD_MEMBER_UPDATE", "GUILD_MEMBER_REMOVE",
+	},
+	types.IntentGuildMessages: {
+		"MESSAGE_CREATE", "MESSAGE_UPDATE", "MESSAGE_DELETE", "MESSAGE_DELETE_BULK",
+	},
+	types.IntentGuildMessageReactions: {
+		"MESSAGE_REACTION_ADD", "MESSAGE_REACTION_REMOVE",
+		"MESSAGE_REACTION_REMOVE_ALL", "MESSAGE_REACTION_REMOVE_EMOJI",
+	},
+	types.IntentGuildVoiceStates: {
+		"VOICE_STATE_UPDATE",
+	},
+	types.IntentGuildPresences: {
+		"PRESENCE_UPDATE",
+	},
+}
+
+// compile turns the spec into a subscriptionMatcher that can be evaluated on the packet hot path
+// without further allocation. Patterns rejected by newGlobTrie are logged against log.
+func (s SubscriptionSpec) compile(log *slog.Logger) *subscriptionMatcher {
+	exact := make(map[string]struct{}, len(s.Events))
+	for _, e := range s.Events {
+		exact[e] = struct{}{}
+	}
+
+	for _, intent := range s.Intents {
+		for _, e := range intentEvents[intent] {
+			exact[e] = struct{}{}
+		}
+	}
+
+	return &subscriptionMatcher{
+		exact: exact,
+		globs: newGlobTrie(s.Patterns, log),
+	}
+}
+
+// subscriptionMatcher is the compiled form of a SubscriptionSpec: an exact-match lookup plus a
+// trie for glob patterns, so matching an event is a map lookup and at most a short trie walk.
+type subscriptionMatcher struct {
+	exact map[string]struct{}
+	globs *globTrie
+}
+
+func (s *subscriptionMatcher) Match(event string) bool {
+	if _, ok := s.exact[event]; ok {
+		return true
+	}
+	return s.globs.Match(event)
+}
+
+// globNode is a trie node keyed by underscore-separated event name segments. exact and wildcard
+// are deliberately distinct: exact marks a pattern that ends here with nothing following, while
+// wildcard marks a pattern that ends here with a trailing "*", matching any continuation. A node
+// can be exact, wildcard, neither (mid-pattern), or both (e.g. "GUILD" and "GUILD_*" together).
+type globNode struct {
+	children map[string]*globNode
+	exact    bool
+	wildcard bool
+}
+
+// globTrie matches event names against a set of "_"-delimited glob patterns whose only supported
+// wildcard is a trailing "*" segment, e.g. "GUILD_*" or "MESSAGE_REACTION_*". A "*" anywhere but
+// the last segment (e.g. "MESSAGE_*_ADD") can't be expressed by this trie, so such patterns are
+// rejected rather than silently truncated to a prefix match.
+type globTrie struct {
+	root *globNode
+}
+
+func newGlobTrie(patterns []string, log *slog.Logger) *globTrie {
+	root := &globNode{children: make(map[string]*globNode)}
+
+	for _, p := range patterns {
+		segments := strings.Split(p, "_")
+
+		starIdx := -1
+		for i, seg := range segments {
+			if seg == "*" {
+				starIdx = i
+				break
+			}
+		}
+		if starIdx >= 0 && starIdx != len(segments)-1 {
+			if log != nil {
+				log.Warn("ignoring subscription pattern: '*' is only supported as the trailing segment", "pattern", p)
+			}
+			continue
+		}
+
+		node := root
+		for _, seg := range segments {
+			if seg == "*" {
+				node.wildcard = true
+				break
+			}
+
+			child, ok := node.children[seg]
+			if !ok {
+				child = &globNode{children: make(map[string]*globNode)}
+				node.children[seg] = child
+			}
+			node = child
+		}
+		if starIdx < 0 {
+			node.exact = true
+		}
+	}
+
+	return &globTrie{root: root}
+}
+
+// Match walks the trie one "_"-delimited segment of event at a time. It indexes '_' manually
+// instead of strings.Split(event, "_"), which would allocate a new slice on every call on this
+// hot path.
+func (t *globTrie) Match(event string) bool {
+	node := t.root
+	rest := event
+
+	for {
+		if node.wildcard {
+			return true
+		}
+
+		seg, tail, more := cutSegment(rest)
+		child, ok := node.children[seg]
+		if !ok {
+			return false
+		}
+		node = child
+
+		if !more {
+			return node.exact
+		}
+		rest = tail
+	}
+}
+
+// cutSegment splits s on the first '_', returning the segment before it, the remainder after it,
+// and whether a '_' was found at all.
+func cutSegment(s string) (seg, rest string, ok bool) {
+	idx := strings.IndexByte(s, '_')
+	if idx < 0 {
+		return s, "", false
+	}
+	return s[:idx], s[idx+1:], true
+}
+
+// matcher returns the manager's currently active subscription matcher.
+func (m *Manager) matcher() *subscriptionMatcher {
+	v, _ := m.subscriptions.Load().(*subscriptionMatcher)
+	if v == nil {
+		return &subscriptionMatcher{exact: map[string]struct{}{}, globs: newGlobTrie(nil, nil)}
+	}
+	return v
+}
+
+// UpdateSubscriptions atomically swaps the active subscription matcher so operators can change
+// which events are forwarded to the broker without restarting shards or dropping in-flight
+// packets.
+func (m *Manager) UpdateSubscriptions(spec SubscriptionSpec) {
+	m.subscriptions.Store(spec.compile(m.logger()))
+}
@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var registry = prometheus.NewRegistry()
+
+// These extend the package's existing TotalShards gauge into a full Prometheus metrics surface.
+var (
+	EventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "events_received_total",
+		Help:      "Total dispatch events received from the gateway, by shard and event name.",
+	}, []string{"shard_id", "event"})
+
+	EventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "events_dropped_total",
+		Help:      "Total dispatch events received but not forwarded to the broker, by shard and event name.",
+	}, []string{"shard_id", "event"})
+
+	PublishLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gateway",
+		Name:      "broker_publish_latency_seconds",
+		Help:      "Latency of publishing a dispatch packet to the broker.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	Identifies = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "identifies_total",
+		Help:      "Total IDENTIFY handshakes completed, by shard.",
+	}, []string{"shard_id"})
+
+	Resumes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "resumes_total",
+		Help:      "Total RESUME handshakes completed, by shard.",
+	}, []string{"shard_id"})
+
+	ReconnectReasons = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "reconnects_total",
+		Help:      "Total reconnects, by shard and reason.",
+	}, []string{"shard_id", "reason"})
+
+	HeartbeatRTT = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gateway",
+		Name:      "heartbeat_rtt_seconds",
+		Help:      "Most recent heartbeat round-trip time, by shard.",
+	}, []string{"shard_id"})
+
+	SessionStartLimitRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gateway",
+		Name:      "session_start_limit_remaining",
+		Help:      "Remaining identify budget reported by Discord's session start limit.",
+	})
+
+	Republishes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "republishes_total",
+		Help:      "Total SEND packets republished because they arrived on a node not hosting that shard.",
+	}, []string{"shard_id"})
+)
+
+func init() {
+	registry.MustRegister(
+		EventsReceived,
+		EventsDropped,
+		PublishLatency,
+		Identifies,
+		Resumes,
+		ReconnectReasons,
+		HeartbeatRTT,
+		SessionStartLimitRemaining,
+		Republishes,
+	)
+}
+
+// Handler returns an http.Handler serving these metrics in the Prometheus exposition format, so
+// callers can mount it (e.g. at /metrics) without pulling in prometheus themselves.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}